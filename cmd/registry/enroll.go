@@ -0,0 +1,78 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package registry
+
+import (
+	"github.com/kaleido-io/kaleido-sdk-go/common"
+	"github.com/kaleido-io/kaleido-sdk-go/kaleido/registry"
+	"github.com/spf13/cobra"
+)
+
+var enrollCreateOrgCmd = &cobra.Command{
+	Use:   "enroll-and-create org",
+	Short: "Enroll for a proof certificate via ACME and register the resulting org",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		acmeEnroll := &registry.ACMEEnroll{
+			Directory:          flags.Lookup("acme-directory").Value.String(),
+			AccountKey:         flags.Lookup("acme-account-key").Value.String(),
+			EABKid:             flags.Lookup("eab-kid").Value.String(),
+			EABHmacKey:         flags.Lookup("eab-hmac-key").Value.String(),
+			ChallengeType:      flags.Lookup("challenge-type").Value.String(),
+			CommonNameTemplate: flags.Lookup("cn-template").Value.String(),
+			HTTP01Addr:         flags.Lookup("http01-addr").Value.String(),
+		}
+		if acmeEnroll.ChallengeType == "dns-01" {
+			acmeEnroll.DNSProvider = registry.ManualDNSProvider{}
+		}
+
+		org := &registry.Organization{
+			Name:       flags.Lookup("name").Value.String(),
+			ACMEEnroll: acmeEnroll,
+		}
+
+		var err error
+		var verifiedOrg *registry.VerifiedOrganization
+		if verifiedOrg, err = org.InvokeCreate(); err != nil {
+			cmd.SilenceUsage = true  // not a usage error at this point
+			cmd.SilenceErrors = true // no need to display Error:, this still displays the error that is returned from RunE
+			return err
+		}
+		common.PrintJSON(verifiedOrg)
+		return nil
+	},
+}
+
+func initEnrollCreateOrgCmd() {
+	flags := enrollCreateOrgCmd.Flags()
+
+	flags.String("name", "", "Name for the organization (defaults to the name suggested by the proof)")
+	flags.String("acme-directory", "", "URL of the ACME v2 directory to enroll against")
+	flags.String("acme-account-key", "", "Path to the ACME account key PEM; auto-created there if missing")
+	flags.String("eab-kid", "", "External Account Binding key id, if the CA requires one")
+	flags.String("eab-hmac-key", "", "External Account Binding HMAC key, if the CA requires one")
+	flags.String("challenge-type", "http-01", "ACME challenge type to satisfy: http-01 or dns-01")
+	flags.String("cn-template", "", "Template for the proof certificate's CN (defaults to {orgid}-{nonce}--{name})")
+	flags.String("http01-addr", "", "Address the http-01 challenge listener binds (defaults to :80; http-01 only)")
+
+	enrollCreateOrgCmd.MarkFlagRequired("acme-directory")
+}
+
+func init() {
+	initEnrollCreateOrgCmd()
+
+	createCmd.AddCommand(enrollCreateOrgCmd)
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package registry
+
+import (
+	"github.com/kaleido-io/kaleido-sdk-go/common"
+	"github.com/kaleido-io/kaleido-sdk-go/kaleido/registry"
+	"github.com/spf13/cobra"
+)
+
+var orgCreateCmd = &cobra.Command{
+	Use:   "org",
+	Short: "Register an organization with the on-chain identity registry",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		org := &registry.Organization{
+			Name:             flags.Lookup("name").Value.String(),
+			SigningKeyFile:   flags.Lookup("signing-key").Value.String(),
+			CertPEMFile:      flags.Lookup("cert").Value.String(),
+			SignerURI:        flags.Lookup("signer-uri").Value.String(),
+			SigningAlgorithm: flags.Lookup("signing-algorithm").Value.String(),
+			HSMModule:        flags.Lookup("hsm-module").Value.String(),
+			HSMPin:           flags.Lookup("hsm-pin").Value.String(),
+			HSMKeyLabel:      flags.Lookup("hsm-key-label").Value.String(),
+		}
+
+		var err error
+		if org.HSMSlot, err = flags.GetInt("hsm-slot"); err != nil {
+			return err
+		}
+		var verifiedOrg *registry.VerifiedOrganization
+		if keyless, _ := flags.GetBool("keyless"); keyless {
+			keylessEnroll := &registry.KeylessEnroll{
+				OIDCIssuer: flags.Lookup("oidc-issuer").Value.String(),
+				FulcioURL:  flags.Lookup("fulcio").Value.String(),
+				OIDCFlow:   flags.Lookup("oidc-flow").Value.String(),
+			}
+			verifiedOrg, err = org.InvokeCreateKeyless(keylessEnroll)
+		} else {
+			verifiedOrg, err = org.InvokeCreate()
+		}
+
+		if err != nil {
+			cmd.SilenceUsage = true  // not a usage error at this point
+			cmd.SilenceErrors = true // no need to display Error:, this still displays the error that is returned from RunE
+			return err
+		}
+		common.PrintJSON(verifiedOrg)
+		return nil
+	},
+}
+
+func initCreateOrgCmd() {
+	flags := orgCreateCmd.Flags()
+
+	flags.String("name", "", "Name for the organization (defaults to the name suggested by the proof)")
+	flags.String("signing-key", "", "Path to the PKCS#8 signing key PEM")
+	flags.String("cert", "", "Path to the pre-issued proof certificate PEM")
+
+	flags.String("signer-uri", "", "Pluggable signer backend instead of --signing-key: pkcs11://, gcpkms://, or awskms:// (azurekms:// is rejected)")
+	flags.String("signing-algorithm", "", "Override the JOSE signature algorithm inferred from the signing key (e.g. PS256 to force RSA-PSS)")
+	flags.String("hsm-module", "", "Path to the PKCS#11 module (.so) to load (pkcs11 signer-uri only)")
+	flags.Int("hsm-slot", 0, "PKCS#11 slot number holding the signing key (pkcs11 signer-uri only)")
+	flags.String("hsm-pin", "", "PKCS#11 token PIN (pkcs11 signer-uri only)")
+	flags.String("hsm-key-label", "", "Label of the PKCS#11 key pair to sign with (pkcs11 signer-uri only)")
+
+	flags.Bool("keyless", false, "Register without a signing key or pre-issued cert, using an OIDC identity instead")
+	flags.String("oidc-issuer", "", "OIDC issuer URL to authenticate against (keyless only)")
+	flags.String("fulcio", "", "Fulcio-style issuer URL that exchanges an OIDC ID token for a proof certificate (keyless only)")
+	flags.String("oidc-flow", "device", "OIDC flow to use when KLD_OIDC_TOKEN is not set: device or client-credentials (keyless only)")
+}
+
+func init() {
+	initCreateOrgCmd()
+
+	createCmd.AddCommand(orgCreateCmd)
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package registry
+
+import (
+	"fmt"
+
+	"github.com/kaleido-io/kaleido-sdk-go/common"
+	"github.com/kaleido-io/kaleido-sdk-go/kaleido/registry"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify registry proofs",
+}
+
+var verifyOrgCmd = &cobra.Command{
+	Use:   "org <name>",
+	Short: "Verify an organization's proof and print its decoded claims and chain status",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		org := &registry.Organization{Name: args[0]}
+		verifiedOrg, err := org.InvokeGet()
+		if err != nil {
+			cmd.SilenceUsage = true  // not a usage error at this point
+			cmd.SilenceErrors = true // no need to display Error:, this still displays the error that is returned from RunE
+			return err
+		}
+
+		verifier := &registry.Verifier{
+			JWKSURL:    flags.Lookup("jwks-url").Value.String(),
+			TrustRoots: flags.Lookup("trust-roots").Value.String(),
+		}
+
+		claims, err := verifier.VerifyOrganization(verifiedOrg)
+		if err != nil {
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			return err
+		}
+
+		fmt.Println("chain status: valid")
+		common.PrintJSON(claims)
+		return nil
+	},
+}
+
+func initVerifyOrgCmd() {
+	flags := verifyOrgCmd.Flags()
+
+	flags.String("jwks-url", "", "JWKS URL to resolve the verification key by kid, when the proof carries no x5c chain")
+	flags.String("trust-roots", "", "Path to a PEM bundle of trusted roots to validate an x5c chain against")
+}
+
+func init() {
+	initVerifyOrgCmd()
+
+	verifyCmd.AddCommand(verifyOrgCmd)
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,294 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const defaultCommonNameTemplate = "{orgid}-{nonce}--{name}"
+
+// DNSProvider publishes and removes the TXT record required to satisfy an
+// ACME dns-01 challenge. Implementations typically wrap a DNS host's API.
+type DNSProvider interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token, keyAuth string) error
+}
+
+// ManualDNSProvider satisfies a dns-01 challenge by printing the record to
+// publish and waiting for the operator to confirm it has propagated, for
+// callers with no scripted DNS API integration.
+type ManualDNSProvider struct{}
+
+func (ManualDNSProvider) Present(domain, token, keyAuth string) error {
+	fmt.Printf("Create a TXT record for _acme-challenge.%s with the value:\n%s\n", domain, keyAuth)
+	fmt.Println("Press Enter once the record has propagated.")
+	_, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return err
+}
+
+func (ManualDNSProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+
+// ACMEEnroll drives in-process certificate enrollment for organization
+// registration against an ACME v2 (RFC 8555) CA, such as step-ca, so an
+// operator does not have to pre-provision a SigningKeyFile/CertPEMFile pair.
+type ACMEEnroll struct {
+	Directory          string      `json:"-"`
+	AccountKey         string      `json:"-"` // path to the account key PEM; auto-created if missing
+	EABKid             string      `json:"-"`
+	EABHmacKey         string      `json:"-"`
+	ChallengeType      string      `json:"-"` // "http-01" or "dns-01"
+	CommonNameTemplate string      `json:"-"` // defaults to "{orgid}-{nonce}--{name}"
+	HTTP01Addr         string      `json:"-"` // address serveHTTP01 binds (http-01 only); defaults to ":80"
+	DNSProvider        DNSProvider `json:"-"`
+}
+
+// Enroll obtains an ephemeral ECDSA P-256 key and a proof certificate from
+// the configured ACME CA and returns them PEM-encoded, ready to take the
+// place of SigningKeyFile/CertPEMFile in createSignedRequestForRegistration.
+// The CN of the requested certificate is rendered from CommonNameTemplate
+// using a fresh registry nonce so the resulting proof satisfies the
+// <orgid>-<nonce>--<name> convention enforced on registration. The same
+// nonce is returned so the caller can reuse it in the registration payload
+// instead of requesting another one, which the server would not recognize
+// as matching the proof.
+func (e *ACMEEnroll) Enroll(org *Organization) (keyPEM, certPEM []byte, nonce string, err error) {
+	if e.Directory == "" {
+		return nil, nil, "", errors.New("ACMEEnroll.Directory is required")
+	}
+
+	accountKey, err := e.loadOrCreateAccountKey()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	client := &acme.Client{DirectoryURL: e.Directory, Key: accountKey}
+	ctx := context.Background()
+	if _, err = client.Discover(ctx); err != nil {
+		return nil, nil, "", fmt.Errorf("fetching ACME directory: %s", err)
+	}
+
+	account := &acme.Account{}
+	if e.EABKid != "" && e.EABHmacKey != "" {
+		account.ExternalAccountBinding = &acme.ExternalAccountBinding{KID: e.EABKid, Key: []byte(e.EABHmacKey)}
+	}
+	if _, err = client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, nil, "", fmt.Errorf("registering ACME account: %s", err)
+	}
+
+	// the registry nonce, not the ACME nonce, is what gets templated into the CN
+	nonce, err = org.generateNonce()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	commonName := e.renderCommonName(org, nonce)
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer zeroKey(certKey)
+
+	order, err := client.AuthorizeOrder(ctx, []acme.AuthzID{{Type: "dns", Value: commonName}})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("creating ACME order: %s", err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err = e.satisfyAuthorization(ctx, client, authzURL); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	csrDER, err := buildCSR(certKey, commonName)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	order, err = e.waitOrder(ctx, client, order.URI)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csrDER, true)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("finalizing ACME order: %s", err)
+	}
+
+	var certBuf []byte
+	for _, c := range der {
+		certBuf = append(certBuf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(certKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return keyPEM, certBuf, nonce, nil
+}
+
+// waitOrder polls the order until it leaves the "pending"/"processing"
+// states, honoring any Retry-After the CA returns.
+func (e *ACMEEnroll) waitOrder(ctx context.Context, client *acme.Client, orderURL string) (*acme.Order, error) {
+	order, err := client.WaitOrder(ctx, orderURL)
+	if err == nil {
+		return order, nil
+	}
+	if ae, ok := err.(*acme.OrderError); ok {
+		return nil, fmt.Errorf("order did not finalize: %s", ae)
+	}
+	return nil, fmt.Errorf("waiting on ACME order: %s", err)
+}
+
+func (e *ACMEEnroll) satisfyAuthorization(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("fetching ACME authorization: %s", err)
+	}
+
+	challengeType := e.ChallengeType
+	if challengeType == "" {
+		challengeType = "http-01"
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == challengeType {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA did not offer a %s challenge", challengeType)
+	}
+
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+
+	switch challengeType {
+	case "http-01":
+		stop, err := e.serveHTTP01(client, chal, keyAuth)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	case "dns-01":
+		if e.DNSProvider == nil {
+			return errors.New("dns-01 challenge requires a DNSProvider")
+		}
+		record, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+		if err = e.DNSProvider.Present(authz.Identifier.Value, chal.Token, record); err != nil {
+			return fmt.Errorf("presenting dns-01 record: %s", err)
+		}
+		defer e.DNSProvider.CleanUp(authz.Identifier.Value, chal.Token, record)
+	default:
+		return fmt.Errorf("unsupported challenge type %q", challengeType)
+	}
+
+	if _, err = client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accepting %s challenge: %s", challengeType, err)
+	}
+	if _, err = client.WaitAuthorization(ctx, authzURL); err != nil {
+		return fmt.Errorf("waiting on authorization: %s", err)
+	}
+	return nil
+}
+
+func (e *ACMEEnroll) renderCommonName(org *Organization, nonce string) string {
+	tmpl := e.CommonNameTemplate
+	if tmpl == "" {
+		tmpl = defaultCommonNameTemplate
+	}
+	replacer := strings.NewReplacer("{orgid}", org.MemberID, "{nonce}", nonce, "{name}", org.Name)
+	return replacer.Replace(tmpl)
+}
+
+func (e *ACMEEnroll) loadOrCreateAccountKey() (*ecdsa.PrivateKey, error) {
+	if e.AccountKey != "" {
+		if pemBytes, err := ioutil.ReadFile(e.AccountKey); err == nil {
+			block, _ := pem.Decode(pemBytes)
+			if block == nil {
+				return nil, errors.New("failed to parse ACME account key")
+			}
+			return x509.ParseECPrivateKey(block.Bytes)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if e.AccountKey != "" {
+		der, err := x509.MarshalECPrivateKey(accountKey)
+		if err != nil {
+			return nil, err
+		}
+		pemBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err = ioutil.WriteFile(e.AccountKey, pemBytes, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return accountKey, nil
+}
+
+func buildCSR(key *ecdsa.PrivateKey, commonName string) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject:            pkix.Name{CommonName: commonName},
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+	}
+	return x509.CreateCertificateRequest(rand.Reader, template, key)
+}
+
+// serveHTTP01 stands up a tiny HTTP server to answer the CA's http-01
+// validation request for chal.Token, and returns a func that tears it down
+// once the caller is done waiting on the authorization. It binds
+// HTTP01Addr (":80" if unset) itself so a failure to bind (port in use,
+// insufficient privilege) surfaces immediately instead of as a later
+// challenge timeout.
+func (e *ACMEEnroll) serveHTTP01(client *acme.Client, chal *acme.Challenge, keyAuth string) (stop func(), err error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/acme-challenge/"+chal.Token, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(keyAuth))
+	})
+
+	addr := e.HTTP01Addr
+	if addr == "" {
+		addr = ":80"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("binding http-01 challenge listener on %s: %s", addr, err)
+	}
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,339 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// KeylessEnroll drives Sigstore-style keyless registration: the caller holds
+// neither a long-lived signing key nor a pre-issued proof certificate, only
+// an OIDC identity. An ephemeral key is generated in memory and a Fulcio-style
+// issuer trades (public key, ID token) for a short-lived certificate binding
+// the two, which plays the role of the usual CertPEMFile.
+type KeylessEnroll struct {
+	OIDCIssuer   string // base URL; used for OIDC discovery when OIDCFlow != "token"
+	FulcioURL    string // issuer endpoint that exchanges (pubkey, ID token) for a cert
+	OIDCFlow     string // "device", "client-credentials", or "token" (KLD_OIDC_TOKEN already set)
+	ClientID     string
+	ClientSecret string
+}
+
+type oidcDiscoveryDoc struct {
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+}
+
+type deviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	Interval                int    `json:"interval"`
+	ExpiresIn               int    `json:"expires_in"`
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+type fulcioResponse struct {
+	Certificate string   `json:"certificate"`
+	Chain       []string `json:"chain"`
+}
+
+// InvokeCreateKeyless registers org without a long-lived signing key: it
+// acquires an OIDC ID token, mints an ephemeral ECDSA P-256 keypair, exchanges
+// the pair for a short-lived proof certificate via the configured Fulcio-style
+// issuer, and signs the registration JWS with that ephemeral key.
+func (org *Organization) InvokeCreateKeyless(keyless *KeylessEnroll) (*VerifiedOrganization, error) {
+	if org.Consortium == "" || org.Environment == "" || org.MemberID == "" {
+		if err := org.populateServiceTargets(); err != nil {
+			return nil, err
+		}
+	}
+
+	signedPayload, err := org.createSignedRequestForKeyless(keyless)
+	if err != nil {
+		return nil, err
+	}
+
+	client := utils().getAPIClient()
+
+	var verifiedOrg VerifiedOrganization
+	response, err := client.R().SetBody(signedPayload).SetResult(&verifiedOrg).Post("/identity")
+
+	err = utils().validateCreateResponse(response, err, "identity")
+	return &verifiedOrg, err
+}
+
+func (org *Organization) createSignedRequestForKeyless(keyless *KeylessEnroll) (*SignedRequest, error) {
+	request := SignedRequest{
+		Consortium:   org.Consortium,
+		Environment:  org.Environment,
+		MembershipID: org.MemberID,
+	}
+
+	idToken, err := keyless.obtainIDToken()
+	if err != nil {
+		return nil, fmt.Errorf("obtaining OIDC ID token: %s", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroKey(certKey)
+
+	certPEM, chainPEM, err := keyless.requestCertificate(certKey, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("requesting keyless proof certificate: %s", err)
+	}
+
+	cert, err := parseProofCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err = resolveNameFromKeylessProof(cert, org); err != nil {
+		return nil, err
+	}
+
+	nonce, err := org.generateNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonBytes, err := org.registrationPayload(nonce, certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	alg, err := algorithmForKey(&certKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: certKey}, nil)
+	if err != nil {
+		return nil, err
+	}
+	object, err := signer.Sign(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	serialized, err := object.CompactSerialize()
+	zeroKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := strings.Split(serialized, ".")
+	request.JWS.Headers = append(request.JWS.Headers, tokens[0])
+	request.JWS.Payload = tokens[1]
+	request.JWS.Signatures = append(request.JWS.Signatures, tokens[2])
+	request.CertChain = chainPEM
+
+	return &request, nil
+}
+
+// resolveNameFromKeylessProof derives/validates org.Name against the
+// identity a Fulcio-style issuer binds to the ephemeral key. Unlike the
+// signing-key path, a keyless proof's CN carries no Kaleido
+// <orgid>-<nonce>--<name> convention: the issuer has no reason to know it.
+// The identity instead lives in the SAN, a URI for a federated identity
+// (e.g. the OIDC subject) or an RFC822Name for an email-verified one.
+func resolveNameFromKeylessProof(cert *x509.Certificate, org *Organization) error {
+	var subject string
+	switch {
+	case len(cert.URIs) > 0:
+		subject = cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		subject = cert.EmailAddresses[0]
+	default:
+		return errors.New("keyless proof certificate carries no URI or email SAN to derive an identity from")
+	}
+
+	if org.Name == "" {
+		org.Name = subject
+		return nil
+	}
+	if org.Name != subject {
+		return fmt.Errorf("specified name %q does not match proof subject %q", org.Name, subject)
+	}
+	return nil
+}
+
+// obtainIDToken returns an OIDC ID token from KLD_OIDC_TOKEN if already set,
+// otherwise drives the configured flow (device code or client-credentials).
+func (k *KeylessEnroll) obtainIDToken() (string, error) {
+	if token := os.Getenv("KLD_OIDC_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	switch k.OIDCFlow {
+	case "device":
+		return k.deviceCodeFlow()
+	case "client-credentials":
+		return k.clientCredentialsFlow()
+	default:
+		return "", errors.New("no OIDC token available: set KLD_OIDC_TOKEN or configure OIDCFlow to \"device\" or \"client-credentials\"")
+	}
+}
+
+func (k *KeylessEnroll) discover() (*oidcDiscoveryDoc, error) {
+	resp, err := http.Get(strings.TrimRight(k.OIDCIssuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var doc oidcDiscoveryDoc
+	if err = json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (k *KeylessEnroll) deviceCodeFlow() (string, error) {
+	doc, err := k.discover()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.PostForm(doc.DeviceAuthorizationEndpoint, map[string][]string{
+		"client_id": {k.ClientID},
+		"scope":     {"openid"},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var device deviceCodeResponse
+	if err = json.NewDecoder(resp.Body).Decode(&device); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("To continue, open %s and confirm code %s\n", device.VerificationURIComplete, device.UserCode)
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		resp, err := http.PostForm(doc.TokenEndpoint, map[string][]string{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {device.DeviceCode},
+			"client_id":   {k.ClientID},
+		})
+		if err != nil {
+			return "", err
+		}
+		var token tokenResponse
+		err = json.NewDecoder(resp.Body).Decode(&token)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		switch token.Error {
+		case "":
+			return token.IDToken, nil
+		case "authorization_pending", "slow_down":
+			continue
+		default:
+			return "", fmt.Errorf("device code flow failed: %s", token.Error)
+		}
+	}
+	return "", errors.New("device code flow timed out waiting for user authorization")
+}
+
+func (k *KeylessEnroll) clientCredentialsFlow() (string, error) {
+	doc, err := k.discover()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.PostForm(doc.TokenEndpoint, map[string][]string{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {k.ClientID},
+		"client_secret": {k.ClientSecret},
+		"scope":         {"openid"},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("client-credentials flow failed: %s", token.Error)
+	}
+	return token.IDToken, nil
+}
+
+// requestCertificate submits the ephemeral public key and OIDC ID token to
+// the configured Fulcio-style issuer, returning the PEM-encoded leaf
+// certificate and the remaining PEM-encoded chain.
+func (k *KeylessEnroll) requestCertificate(certKey *ecdsa.PrivateKey, idToken string) (certPEM []byte, chainPEM []string, err error) {
+	if k.FulcioURL == "" {
+		return nil, nil, errors.New("KeylessEnroll.FulcioURL is required")
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&certKey.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"publicKey": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(k.FulcioURL, "/")+"/api/v2/signingCert", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+idToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("issuer returned status %d", resp.StatusCode)
+	}
+
+	var fulcio fulcioResponse
+	if err = json.NewDecoder(resp.Body).Decode(&fulcio); err != nil {
+		return nil, nil, err
+	}
+	if fulcio.Certificate == "" {
+		return nil, nil, errors.New("issuer returned no certificate")
+	}
+
+	return []byte(fulcio.Certificate), fulcio.Chain, nil
+}
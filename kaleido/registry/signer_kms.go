@@ -0,0 +1,294 @@
+package registry
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awskms "github.com/aws/aws-sdk-go/service/kms"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// kmsSigner signs via a cloud KMS key referenced by org.SignerURI, e.g.
+// gcpkms://projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1
+// or awskms:///arn:aws:kms:region:account:key/key-id.
+type kmsSigner struct {
+	pub       crypto.PublicKey
+	curveBits int
+	hash      crypto.Hash
+	sign      func(digest []byte) (asn1DER []byte, err error)
+	// algorithm, when set, overrides the algorithm algorithmForKey would
+	// otherwise infer from the key (e.g. to force PS256 over RS256).
+	algorithm jose.SignatureAlgorithm
+}
+
+// hashForCurveBits returns the digest algorithm matching the JOSE algorithm
+// algorithmForKey would select for an ECDSA key of the given curve bit size,
+// so the digest actually signed always agrees with the alg the JWS header
+// advertises (ES256/SHA-256, ES384/SHA-384, ES512/SHA-512).
+func hashForCurveBits(curveBits int) (crypto.Hash, error) {
+	switch curveBits {
+	case 256:
+		return crypto.SHA256, nil
+	case 384:
+		return crypto.SHA384, nil
+	case 521: // not a typo, ES512 == 521 curve bits
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported ECDSA curve bit size %d", curveBits)
+	}
+}
+
+// sumDigest hashes payload with h, returning the raw digest bytes.
+func sumDigest(h crypto.Hash, payload []byte) []byte {
+	switch h {
+	case crypto.SHA384:
+		sum := sha512.Sum384(payload)
+		return sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(payload)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(payload)
+		return sum[:]
+	}
+}
+
+func newKMSSigner(u *url.URL, algorithmOverride string) (*kmsSigner, error) {
+	var signer *kmsSigner
+	var err error
+	switch u.Scheme {
+	case "gcpkms":
+		signer, err = newGCPKMSSigner(u)
+	case "awskms":
+		signer, err = newAWSKMSSigner(u)
+	case "azurekms":
+		return nil, fmt.Errorf("azurekms signer not yet implemented, contributions welcome")
+	default:
+		return nil, fmt.Errorf("unsupported KMS scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer.algorithm = jose.SignatureAlgorithm(algorithmOverride)
+	return signer, nil
+}
+
+func newGCPKMSSigner(u *url.URL) (*kmsSigner, error) {
+	ctx := context.Background()
+	keyName := u.Host + u.Path
+
+	client, err := kms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dialing GCP KMS: %s", err)
+	}
+
+	pubResp, err := client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: keyName})
+	if err != nil {
+		return nil, fmt.Errorf("fetching GCP KMS public key: %s", err)
+	}
+	pub, curveBits, err := parseECPublicKeyPEM([]byte(pubResp.Pem))
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashForCurveBits(curveBits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kmsSigner{
+		pub:       pub,
+		curveBits: curveBits,
+		hash:      hash,
+		sign: func(digest []byte) ([]byte, error) {
+			sum := sumDigest(hash, digest)
+			kmsDigest := &kmspb.Digest{}
+			switch hash {
+			case crypto.SHA384:
+				kmsDigest.Digest = &kmspb.Digest_Sha384{Sha384: sum}
+			case crypto.SHA512:
+				kmsDigest.Digest = &kmspb.Digest_Sha512{Sha512: sum}
+			default:
+				kmsDigest.Digest = &kmspb.Digest_Sha256{Sha256: sum}
+			}
+			resp, err := client.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+				Name:   keyName,
+				Digest: kmsDigest,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Signature, nil
+		},
+	}, nil
+}
+
+func newAWSKMSSigner(u *url.URL) (*kmsSigner, error) {
+	keyID := strings.TrimPrefix(u.Path, "/")
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session: %s", err)
+	}
+	client := awskms.New(sess)
+
+	pubOut, err := client.GetPublicKey(&awskms.GetPublicKeyInput{KeyId: &keyID})
+	if err != nil {
+		return nil, fmt.Errorf("fetching AWS KMS public key: %s", err)
+	}
+	pub, curveBits, err := parseECPublicKeyDER(pubOut.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashForCurveBits(curveBits)
+	if err != nil {
+		return nil, err
+	}
+	awsAlg, err := awsSigningAlgorithmForHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kmsSigner{
+		pub:       pub,
+		curveBits: curveBits,
+		hash:      hash,
+		sign: func(digest []byte) ([]byte, error) {
+			sum := sumDigest(hash, digest)
+			resp, err := client.Sign(&awskms.SignInput{
+				KeyId:            &keyID,
+				Message:          sum,
+				MessageType:      aws.String("DIGEST"),
+				SigningAlgorithm: aws.String(awsAlg),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Signature, nil
+		},
+	}, nil
+}
+
+// awsSigningAlgorithmForHash returns the AWS KMS SigningAlgorithm name
+// matching hash, for an ECDSA key.
+func awsSigningAlgorithmForHash(hash crypto.Hash) (string, error) {
+	switch hash {
+	case crypto.SHA256:
+		return "ECDSA_SHA_256", nil
+	case crypto.SHA384:
+		return "ECDSA_SHA_384", nil
+	case crypto.SHA512:
+		return "ECDSA_SHA_512", nil
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %v", hash)
+	}
+}
+
+func (s *kmsSigner) Public() crypto.PublicKey { return s.pub }
+
+// SignJWS signs payload via the backing KMS, re-encoding the ASN.1 DER
+// signature KMS returns into the fixed-width r||s form JOSE requires, through
+// a jose.OpaqueSigner so CompactSerialize still drives the wire format.
+func (s *kmsSigner) SignJWS(payload []byte) (string, error) {
+	natural, err := algorithmForKey(s.Public())
+	if err != nil {
+		return "", err
+	}
+	alg := natural
+	if s.algorithm != "" {
+		// the digest and r||s encoding are fixed to the KMS key's curve at
+		// construction time, so only the alg the curve naturally produces
+		// can be honored: anything else would advertise a header the bytes
+		// actually signed don't match.
+		if s.algorithm != natural {
+			return "", fmt.Errorf("signing algorithm %q is incompatible with this KMS key: requires %q", s.algorithm, natural)
+		}
+		alg = s.algorithm
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: &kmsOpaqueSigner{kmsSigner: s, alg: alg}}, nil)
+	if err != nil {
+		return "", err
+	}
+	object, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return object.CompactSerialize()
+}
+
+// kmsOpaqueSigner adapts kmsSigner to jose.OpaqueSigner so go-jose can drive
+// the compact serialization while the actual signing happens inside KMS.
+type kmsOpaqueSigner struct {
+	*kmsSigner
+	alg jose.SignatureAlgorithm
+}
+
+func (s *kmsOpaqueSigner) Public() *jose.JSONWebKey {
+	return &jose.JSONWebKey{Key: s.pub}
+}
+
+func (s *kmsOpaqueSigner) Algs() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{s.alg}
+}
+
+func (s *kmsOpaqueSigner) SignPayload(payload []byte, alg jose.SignatureAlgorithm) ([]byte, error) {
+	der, err := s.sign(payload)
+	if err != nil {
+		return nil, err
+	}
+	return asn1ECDSASignatureToJOSE(der, s.curveBits)
+}
+
+// parseECPublicKeyPEM parses a PEM-encoded SubjectPublicKeyInfo, as returned
+// by GCP KMS's GetPublicKey.
+func parseECPublicKeyPEM(pemBytes []byte) (*ecdsa.PublicKey, int, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, 0, fmt.Errorf("failed to parse KMS public key PEM")
+	}
+	return parseECPublicKeyDER(block.Bytes)
+}
+
+// parseECPublicKeyDER parses a DER-encoded SubjectPublicKeyInfo, as returned
+// by AWS KMS's GetPublicKey.
+func parseECPublicKeyDER(der []byte) (*ecdsa.PublicKey, int, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parsing KMS public key: %s", err)
+	}
+	ecdsaKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported KMS public key type %T", pub)
+	}
+	return ecdsaKey, ecdsaKey.Curve.Params().BitSize, nil
+}
+
+// asn1ECDSASignatureToJOSE converts the ASN.1 DER ECDSA signature KMS APIs
+// return into the fixed-width big-endian r||s encoding JOSE/JWS requires.
+func asn1ECDSASignatureToJOSE(der []byte, curveBits int) ([]byte, error) {
+	var parsed struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing ASN.1 ECDSA signature: %s", err)
+	}
+
+	keyBytes := (curveBits + 7) / 8
+	rs := make([]byte, 2*keyBytes)
+	parsed.R.FillBytes(rs[:keyBytes])
+	parsed.S.FillBytes(rs[keyBytes:])
+	return rs, nil
+}
@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// pkcs11Signer signs using a key held in a PKCS#11 token (an HSM), selected
+// via --hsm-module/--hsm-slot/--hsm-pin/--hsm-key-label. The private key
+// material never leaves the token.
+type pkcs11Signer struct {
+	ctx     *crypto11.Context
+	signer  crypto.Signer
+	keyName string
+	// algorithm, when set, overrides the algorithm algorithmForKey would
+	// otherwise infer from the key (e.g. to force PS256 over RS256).
+	algorithm jose.SignatureAlgorithm
+}
+
+func newPKCS11Signer(org *Organization) (*pkcs11Signer, error) {
+	if org.HSMModule == "" || org.HSMKeyLabel == "" {
+		return nil, fmt.Errorf("pkcs11 signer requires HSMModule and HSMKeyLabel")
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       org.HSMModule,
+		SlotNumber: &org.HSMSlot,
+		Pin:        org.HSMPin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening PKCS#11 session: %s", err)
+	}
+
+	signer, err := ctx.FindKeyPair(nil, []byte(org.HSMKeyLabel))
+	if err != nil {
+		return nil, fmt.Errorf("locating PKCS#11 key %q: %s", org.HSMKeyLabel, err)
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("no PKCS#11 key found with label %q", org.HSMKeyLabel)
+	}
+
+	return &pkcs11Signer{ctx: ctx, signer: signer, keyName: org.HSMKeyLabel, algorithm: jose.SignatureAlgorithm(org.SigningAlgorithm)}, nil
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey { return s.signer.Public() }
+
+func (s *pkcs11Signer) SignJWS(payload []byte) (string, error) {
+	alg := s.algorithm
+	if alg == "" {
+		var err error
+		if alg, err = algorithmForKey(s.Public()); err != nil {
+			return "", err
+		}
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: s.signer}, nil)
+	if err != nil {
+		return "", err
+	}
+	object, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return object.CompactSerialize()
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestAlgorithmForKey(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		pub  interface{}
+		want jose.SignatureAlgorithm
+	}{
+		{"P-256", p256.Public(), jose.ES256},
+		{"P-384", p384.Public(), jose.ES384},
+		{"P-521", p521.Public(), jose.ES512},
+		{"RSA-2048", rsa2048.Public(), jose.RS256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := algorithmForKey(tt.pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("algorithmForKey(%s) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := algorithmForKey("not a key"); err == nil {
+		t.Error("expected an error for an unsupported key type")
+	}
+}
+
+func TestHashForCurveBits(t *testing.T) {
+	tests := []struct {
+		bits int
+		want crypto.Hash
+	}{
+		{256, crypto.SHA256},
+		{384, crypto.SHA384},
+		{521, crypto.SHA512},
+	}
+	for _, tt := range tests {
+		got, err := hashForCurveBits(tt.bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("hashForCurveBits(%d) = %v, want %v", tt.bits, got, tt.want)
+		}
+	}
+
+	if _, err := hashForCurveBits(192); err == nil {
+		t.Error("expected an error for an unsupported curve bit size")
+	}
+}
+
+func TestAsn1ECDSASignatureToJOSE(t *testing.T) {
+	r := big.NewInt(0x1234)
+	s := big.NewInt(0x5678)
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := asn1ECDSASignatureToJOSE(der, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 64 {
+		t.Fatalf("expected a 64-byte r||s for a P-256 signature, got %d", len(rs))
+	}
+	if got := new(big.Int).SetBytes(rs[:32]); got.Cmp(r) != 0 {
+		t.Errorf("r = %v, want %v", got, r)
+	}
+	if got := new(big.Int).SetBytes(rs[32:]); got.Cmp(s) != 0 {
+		t.Errorf("s = %v, want %v", got, s)
+	}
+
+	if _, err := asn1ECDSASignatureToJOSE([]byte("not asn1"), 256); err == nil {
+		t.Error("expected an error for malformed ASN.1")
+	}
+}
@@ -0,0 +1,263 @@
+package registry
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// Verifier checks the JWS proofs produced by organization registration
+// against a configured trust anchor: an x5c chain embedded in the JWS, a
+// JWKS endpoint, or a local trust store of PEM-encoded roots.
+type Verifier struct {
+	JWKSURL    string // JWKS endpoint used when the JWS carries only a kid
+	TrustRoots string // path to a PEM bundle of trusted roots
+
+	jwksKeys   jose.JSONWebKeySet
+	jwksETag   string
+	jwksExpiry time.Time
+}
+
+// VerifyOrganization verifies vo's proof JWS and returns its decoded claims.
+// The verification key is resolved from an x5c header on the JWS if present,
+// otherwise from the configured JWKS endpoint by kid. When an x5c chain is
+// present, it is also validated against TrustRoots, which is required in
+// that case: an unanchored x5c chain proves nothing about the key.
+func (v *Verifier) VerifyOrganization(vo *VerifiedOrganization) (map[string]interface{}, error) {
+	if vo.Proof == nil || len(vo.Proof.Headers) == 0 || len(vo.Proof.Signatures) == 0 {
+		return nil, errors.New("organization has no proof to verify")
+	}
+
+	compact := vo.Proof.Headers[0] + "." + vo.Proof.Payload + "." + vo.Proof.Signatures[0]
+	object, err := jose.ParseSigned(compact)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proof JWS: %s", err)
+	}
+	if len(object.Signatures) == 0 {
+		return nil, errors.New("proof JWS has no signatures")
+	}
+	sig := object.Signatures[0]
+
+	pub, chain, err := v.resolveKey(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := object.Verify(pub)
+	if err != nil {
+		return nil, fmt.Errorf("verifying proof signature: %s", err)
+	}
+
+	if len(chain) > 0 {
+		if err = v.verifyChainAgainstRoots(chain); err != nil {
+			return nil, fmt.Errorf("verifying proof certificate chain: %s", err)
+		}
+		if err = checkCommonNameBinding(chain[0], vo.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	var claims map[string]interface{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkCommonNameBinding enforces that leaf's CN, which follows the
+// <orgid>-<nonce>--<name> convention enforced at registration (see
+// Organization.resolveNameFromProof), actually names orgName. Otherwise a
+// proof whose chain verifies against a trusted root could still vouch for a
+// different organization than the one it's attached to.
+func checkCommonNameBinding(leaf *x509.Certificate, orgName string) error {
+	CNTokens := strings.Split(leaf.Subject.CommonName, "-")
+	if len(CNTokens) != 4 {
+		return errors.New("proof certificate common name does not follow the format of <orgid>-<nonce>--<name>")
+	}
+
+	if !strings.Contains(orgName, CNTokens[3]) || !strings.Contains(orgName, CNTokens[0]) {
+		return fmt.Errorf("proof certificate common name does not match organization: must contain '%s' and '%s'", CNTokens[3], CNTokens[0])
+	}
+	return nil
+}
+
+// VerifyChain verifies every organization in vos, and for any with a
+// ParentID also verifies the parent (fetching it via InvokeGet if it is not
+// already in vos) and checks that the child's proof was signed during the
+// parent's proof validity window.
+func (v *Verifier) VerifyChain(vos []*VerifiedOrganization) error {
+	byID := make(map[string]*VerifiedOrganization, len(vos))
+	for _, vo := range vos {
+		byID[vo.ID] = vo
+	}
+
+	for _, vo := range vos {
+		claims, err := v.VerifyOrganization(vo)
+		if err != nil {
+			return fmt.Errorf("verifying %s: %s", vo.Name, err)
+		}
+		if vo.ParentID == "" {
+			continue
+		}
+
+		parent, ok := byID[vo.ParentID]
+		if !ok {
+			org := &Organization{Name: vo.ParentID}
+			if parent, err = org.InvokeGet(); err != nil {
+				return fmt.Errorf("fetching parent %s of %s: %s", vo.ParentID, vo.Name, err)
+			}
+		}
+
+		parentClaims, err := v.VerifyOrganization(parent)
+		if err != nil {
+			return fmt.Errorf("verifying parent %s of %s: %s", parent.Name, vo.Name, err)
+		}
+		if err = checkValidityWindow(claims, parentClaims); err != nil {
+			return fmt.Errorf("%s: %s", vo.Name, err)
+		}
+	}
+	return nil
+}
+
+// resolveKey returns the public key that should verify sig, preferring an
+// x5c-embedded chain over a JWKS lookup by kid. The returned chain is nil
+// when the key came from JWKS rather than an x5c header.
+func (v *Verifier) resolveKey(sig jose.Signature) (crypto.PublicKey, []*x509.Certificate, error) {
+	if len(sig.Header.Certificates) > 0 {
+		return sig.Header.Certificates[0].PublicKey, sig.Header.Certificates, nil
+	}
+
+	if v.JWKSURL == "" {
+		return nil, nil, errors.New("proof JWS carries no x5c chain and no JWKS URL is configured")
+	}
+
+	keys, err := v.fetchJWKS()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, k := range keys.Keys {
+		if k.KeyID == sig.Header.KeyID {
+			return k.Key, nil, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no JWKS key found for kid %q", sig.Header.KeyID)
+}
+
+// fetchJWKS returns the cached key set if it is still fresh per Cache-Control,
+// otherwise refetches, sending If-None-Match so an unchanged set costs only a
+// round trip.
+func (v *Verifier) fetchJWKS() (jose.JSONWebKeySet, error) {
+	if time.Now().Before(v.jwksExpiry) && len(v.jwksKeys.Keys) > 0 {
+		return v.jwksKeys, nil
+	}
+
+	req, err := http.NewRequest("GET", v.JWKSURL, nil)
+	if err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+	if v.jwksETag != "" {
+		req.Header.Set("If-None-Match", v.jwksETag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		v.jwksExpiry = cacheExpiry(resp.Header)
+		return v.jwksKeys, nil
+	}
+	if resp.StatusCode >= 300 {
+		return jose.JSONWebKeySet{}, fmt.Errorf("fetching JWKS: status %d", resp.StatusCode)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+
+	v.jwksKeys = keys
+	v.jwksETag = resp.Header.Get("ETag")
+	v.jwksExpiry = cacheExpiry(resp.Header)
+	return keys, nil
+}
+
+// cacheExpiry reads max-age off a Cache-Control header, falling back to a
+// conservative 5 minute default so a CA that sends no caching headers still
+// gets some JWKS reuse across InvokeGet calls.
+func cacheExpiry(h http.Header) time.Time {
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, "max-age=") {
+			if secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				return time.Now().Add(time.Duration(secs) * time.Second)
+			}
+		}
+	}
+	return time.Now().Add(5 * time.Minute)
+}
+
+// verifyChainAgainstRoots validates chain against TrustRoots. TrustRoots is
+// required: an x5c chain is attacker-supplied inside the JWS itself, so
+// without a configured trust anchor there is nothing to validate it
+// against, and accepting it unanchored would let any self-signed cert with
+// a matching CN pass as a valid proof.
+func (v *Verifier) verifyChainAgainstRoots(chain []*x509.Certificate) error {
+	if v.TrustRoots == "" {
+		return errors.New("proof JWS carries an x5c chain but no TrustRoots is configured to anchor it")
+	}
+
+	rootsPEM, err := ioutil.ReadFile(v.TrustRoots)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootsPEM) {
+		return errors.New("no certificates found in trust store")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err = chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+// checkValidityWindow enforces that the child's proof certificate was issued
+// while the parent's proof certificate was itself valid.
+func checkValidityWindow(childClaims, parentClaims map[string]interface{}) error {
+	childCert, err := certFromClaims(childClaims)
+	if err != nil {
+		return err
+	}
+	parentCert, err := certFromClaims(parentClaims)
+	if err != nil {
+		return err
+	}
+
+	if childCert.NotBefore.Before(parentCert.NotBefore) || childCert.NotBefore.After(parentCert.NotAfter) {
+		return fmt.Errorf("proof was not signed during parent's validity window (%s - %s)", parentCert.NotBefore, parentCert.NotAfter)
+	}
+	return nil
+}
+
+func certFromClaims(claims map[string]interface{}) (*x509.Certificate, error) {
+	proofStr, ok := claims["proof"].(string)
+	if !ok {
+		return nil, errors.New("claims missing proof certificate")
+	}
+	return parseProofCertificate([]byte(proofStr))
+}